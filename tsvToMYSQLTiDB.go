@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/csv"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"io"
+	"math/rand"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 var (
@@ -20,16 +28,54 @@ var (
 	FILENAME = ""
 	DELIMITER = '\t'		// default delimiter for csv files
 	MAX_SQL_CONNECTIONS = 100	// default max_connections of mysql
+	WORKERS = 4			// default size of the insert worker pool
+	BATCH_SIZE = 500		// default number of rows per batch insert
+	MAX_RETRIES = 5			// default number of retries for a batch hitting a transient error
+	REJECT_FILE = ""		// TSV file that permanently failed rows are appended to
+	MODE = "insert"			// "insert" (row/batch INSERTs) or "load" (LOAD DATA LOCAL INFILE)
+	INPUT_FORMAT = "tsv"			// "tsv", "csv", "jsonl" or "sql"
+	COLUMNS_FLAG = ""			// explicit comma-separated column list, used by jsonl input to skip inference
+	INFER_LINES = 1000			// number of jsonl lines scanned to infer the column union when -columns is unset
+	METRICS_ADDR = ""			// address to serve Prometheus metrics on, e.g. ":9090"; disabled when empty
+	QUERY_TIMEOUT = 30 * time.Second	// per-query timeout, 0 disables
+	LOAD_TIMEOUT = time.Duration(0)		// timeout for the single -mode load LOAD DATA statement, 0 disables (bounded only by ctx/SIGINT)
+	CONN_TIMEOUT = time.Duration(0)		// dial timeout injected into the DSN, 0 uses the driver default
+	READ_TIMEOUT = time.Duration(0)		// read timeout injected into the DSN, 0 uses the driver default
 	CONN_STR = ""
 	ON_DUP_KEYS_UPDATE = false
 )
 
+// name the mysql driver registers stdin under for LOAD DATA LOCAL INFILE
+const loadReaderHandler = "stdin"
+
+// MySQL/TiDB error numbers that are safe to retry: deadlock, lock wait timeout,
+// and TiDB's optimistic/pessimistic write conflict errors
+var retryableErrNumbers = map[uint16]bool{
+	1213: true,	// deadlock found when trying to get lock
+	1205: true,	// lock wait timeout exceeded
+	8022: true,	// TiDB: write conflict
+	9007: true,	// TiDB: write conflict (pessimistic)
+}
+
 // parse flags and command line arguments
 func parseSysArgs() {
 	db := flag.String("db", CONN_STR, "connection string example shopee_foody_data:@tcp(db-master-foody-algo-data-id-sg1-live.shopeemobile.com:6606)/shopee_foody_algo_data_id_db")
 	table := flag.String("table", TABLENAME, "Name of MySQL database table.")
 	delimiter := flag.String("d", string(DELIMITER), "Delimiter used in .csv file.")
 	max_conns := flag.Int("conns", MAX_SQL_CONNECTIONS, "Maximum number of concurrent connections to database. Value depends on your MySQL configuration.")
+	workers := flag.Int("workers", WORKERS, "Number of concurrent workers reading off the row channel and inserting batches.")
+	batch := flag.Int("batch", BATCH_SIZE, "Number of rows a worker accumulates before issuing a single multi-row INSERT.")
+	max_retries := flag.Int("max_retries", MAX_RETRIES, "Number of times to retry a batch that fails with a transient error (deadlock, lock wait timeout, write conflict, bad connection).")
+	reject_file := flag.String("reject_file", REJECT_FILE, "TSV file that rows from permanently failed batches are appended to, so they can be re-run later. Disabled when empty.")
+	mode := flag.String("mode", MODE, "Ingestion mode: \"insert\" for row/batch INSERTs, \"load\" for a single LOAD DATA LOCAL INFILE (only supports -input tsv).")
+	query_timeout := flag.Duration("query_timeout", QUERY_TIMEOUT, "Timeout for a single query/transaction; 0 disables.")
+	load_timeout := flag.Duration("load_timeout", LOAD_TIMEOUT, "Timeout for the single -mode load LOAD DATA statement; 0 disables (bounded only by ctx/SIGINT). Independent of -query_timeout since a bulk load can legitimately run far longer than a batch insert.")
+	conn_timeout := flag.Duration("conn_timeout", CONN_TIMEOUT, "Dial timeout injected into the DSN as timeout=; 0 uses the driver default.")
+	read_timeout := flag.Duration("read_timeout", READ_TIMEOUT, "Read timeout injected into the DSN as readTimeout=; 0 uses the driver default.")
+	input := flag.String("input", INPUT_FORMAT, "Input format: \"tsv\", \"csv\", \"jsonl\" or \"sql\". \"sql\" ignores -table and runs each pt-query-digest-delimited statement through the worker pool.")
+	columns := flag.String("columns", COLUMNS_FLAG, "Explicit comma-separated column list for -input jsonl, skipping key inference.")
+	infer_lines := flag.Int("infer_lines", INFER_LINES, "Number of -input jsonl lines scanned to infer the column union when -columns is unset.")
+	metrics_addr := flag.String("metrics_addr", METRICS_ADDR, "Address to serve Prometheus metrics on, e.g. \":9090\". Disabled when empty.")
 	on_dup_keys_update := flag.Bool("enable_update", ON_DUP_KEYS_UPDATE, "enable insert on duplicate key update, BE CAREFUL, it might cause deadlock.")
 
 	flag.Parse()
@@ -37,6 +83,19 @@ func parseSysArgs() {
 	TABLENAME = *table
 	DELIMITER = []rune(*delimiter)[0]
 	MAX_SQL_CONNECTIONS = *max_conns
+	WORKERS = *workers
+	BATCH_SIZE = *batch
+	MAX_RETRIES = *max_retries
+	REJECT_FILE = *reject_file
+	MODE = *mode
+	QUERY_TIMEOUT = *query_timeout
+	LOAD_TIMEOUT = *load_timeout
+	CONN_TIMEOUT = *conn_timeout
+	READ_TIMEOUT = *read_timeout
+	INPUT_FORMAT = *input
+	COLUMNS_FLAG = *columns
+	INFER_LINES = *infer_lines
+	METRICS_ADDR = *metrics_addr
 	CONN_STR = *db
 	ON_DUP_KEYS_UPDATE = *on_dup_keys_update
 }
@@ -45,27 +104,59 @@ func main() {
 
 	parseSysArgs()
 
+	// --------------------------------------------------------------------------
+	// top-level context, cancelled on SIGINT/SIGTERM so a hung load shuts down
+	// cleanly and still prints its final report instead of being killed outright
+	// --------------------------------------------------------------------------
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("received %s, draining in-flight batches...\n", sig)
+		cancel()
+	}()
+
+	startMetricsServer(METRICS_ADDR)
+
 	// --------------------------------------------------------------------------
 	// prepare buffered file reader
 	// --------------------------------------------------------------------------
 	file := os.NewFile(uintptr(syscall.Stdin), "/dev/stdin")
-	reader := csv.NewReader(file)
-	reader.Comma = DELIMITER		// set custom comma for reader (default: ',')
-	// a,b,c"d" working as 3 cols when LazyQuotes is true
-	reader.LazyQuotes = true
 
 	// --------------------------------------------------------------------------
 	// database connection setup
 	// --------------------------------------------------------------------------
 
-	db, err := sql.Open("mysql", CONN_STR)
+	dsn := CONN_STR
+	if MODE == "load" {
+		dsn = appendDSNParam(dsn, "allowAllFiles=true")	// required by the driver to serve LOAD DATA LOCAL INFILE from a registered reader
+	}
+	if CONN_TIMEOUT > 0 {
+		dsn = appendDSNParam(dsn, "timeout="+CONN_TIMEOUT.String())
+	}
+	if READ_TIMEOUT > 0 {
+		dsn = appendDSNParam(dsn, "readTimeout="+READ_TIMEOUT.String())
+	}
+
+	if MODE == "load" && INPUT_FORMAT != "tsv" {
+		// nullTokenFilter splits on DELIMITER byte-by-byte with no CSV-quote
+		// awareness, so a quoted field containing an embedded delimiter would
+		// silently corrupt the LOAD DATA stream; tsv is the only format whose
+		// fields cannot legally contain an unescaped delimiter
+		log.Fatal("-mode load only supports -input tsv")
+	}
+
+	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		log.Fatal(err.Error())
 		return
 	}
 
 	// check database connection
-	err = db.Ping()
+	err = db.PingContext(ctx)
 	if err != nil {
 		log.Fatal(err.Error())
 		return
@@ -74,6 +165,29 @@ func main() {
 	db.SetMaxIdleConns(MAX_SQL_CONNECTIONS)
 	defer db.Close()
 
+	if INPUT_FORMAT == "sql" {
+		runSQLMode(ctx, file, db)
+		return
+	}
+
+	if MODE == "load" {
+		loadFromReader(ctx, file, db)
+		return
+	}
+
+	columns, rows := startRowFeeder(ctx, file)
+
+	// --------------------------------------------------------------------------
+	// reject file for batches that exhaust their retries
+	// --------------------------------------------------------------------------
+	var rejects *rejectWriter
+	if REJECT_FILE != "" {
+		rejects, err = newRejectWriter(REJECT_FILE)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer rejects.Close()
+	}
 
 	// --------------------------------------------------------------------------
 	// read rows and insert into database
@@ -81,127 +195,680 @@ func main() {
 
 	start := time.Now()									// to measure execution time
 
-
-	query := ""											// query statement
-	callback 	:= make(chan int, 1024)						// callback channel for insert goroutines
-	connections := int64(0)									// number of concurrent connections
-	insertions := int64(0)									// counts how many insertions have finished
+	callback 	:= make(chan int, 1024)						// callback channel, carries +rows on success, -rows on batch failure
+	done		:= make(chan struct{})						// signals the callback consumer has drained the channel
+	insertions := int64(0)									// counts how many rows have been processed
 	fails := int64(0)
-	available 	:= make(chan bool, MAX_SQL_CONNECTIONS)	// buffered channel, holds number of available connections
-	for i := 0; i < MAX_SQL_CONNECTIONS; i++ {
-		available <- true
-	}
-
+	started := int64(0)									// number of workers that prepared their insert statement successfully
 
 	// start status logger
-	startLogger(&insertions, &fails, &connections)
+	startLogger()
 
-	// start connection controller
-	startConnectionController(&insertions, &fails, &connections, callback, available)
+	// start callback consumer, tallies insertions/fails as batches complete
+	startConnectionController(&insertions, &fails, callback, done)
 
 	var wg sync.WaitGroup
-	id := -1
-	isFirstRow := true
+	for i := 0; i < WORKERS; i++ {
+		wg.Add(1)
+		go worker(ctx, i, columns, db, rows, callback, &wg, rejects, &started)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt64(&started) == 0 {
+		// every worker failed to prepare its insert statement (bad -table, missing
+		// grant, ...): draining zero rows must not be reported as a clean "nothing
+		// to do" run
+		log.Fatal("no worker was able to prepare the insert statement, aborting")
+	}
+
+	close(callback)
+	<-done
+
+	elapsed := time.Since(start)
+	log.Printf("Execution time: %s\n", elapsed)
+	log.Printf("Status: %d insertions, %d valid insertions\n", insertions, insertions - fails)
+	log.Printf("QPS: %d , Valid QPS: %d\n",
+			  insertions *1000000000/elapsed.Nanoseconds(), (insertions - fails) *1000000000/elapsed.Nanoseconds())
+}
+
+// startRowFeeder parses the column list and starts a goroutine streaming the
+// remaining rows onto a channel, dispatching on INPUT_FORMAT. The channel is
+// closed once the input is exhausted or ctx is cancelled.
+func startRowFeeder(ctx context.Context, file *os.File) ([]string, <-chan []string) {
+	if INPUT_FORMAT == "jsonl" {
+		return startJSONLFeeder(ctx, file)
+	}
+	return startCSVFeeder(ctx, file)
+}
 
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+// startCSVFeeder reads the header row synchronously for the column list, then
+// streams the remaining delimited rows onto a channel in the background
+func startCSVFeeder(ctx context.Context, file *os.File) ([]string, <-chan []string) {
+	reader := csv.NewReader(file)
+	reader.Comma = DELIMITER		// set custom comma for reader (default: ',')
+	// a,b,c"d" working as 3 cols when LazyQuotes is true
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1	// checked manually below, per row, so one ragged row can be skipped instead of fataling or desyncing the whole batch
+
+	header, err := reader.Read()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	columns := parseColumnNames(header)
+
+	rows := make(chan []string, 1024)
+	go func() {
+		defer close(rows)
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			record, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				// a single malformed row must not abort the whole load: log and
+				// skip it, same as startJSONLFeeder does for bad jsonl lines
+				log.Printf("skipping malformed csv row: %s\n", err.Error())
+				continue
+			}
+			if len(record) != len(columns) {
+				// FieldsPerRecord is disabled above so a ragged row reaches here
+				// instead of erroring; queuing it anyway would desync flattenArgs'
+				// argument count for the whole batch it lands in, failing every
+				// other row alongside it, so skip just this one
+				log.Printf("skipping csv row with %d fields, expected %d\n", len(record), len(columns))
+				continue
+			}
+			select {
+			case rows <- record:
+			case <-ctx.Done():
+				return
+			}
 		}
-		if err != nil {
+	}()
+	return columns, rows
+}
+
+// startJSONLFeeder determines the column list for -input jsonl (from -columns,
+// or by scanning up to INFER_LINES lines for the union of their keys), then
+// streams the remaining lines onto a channel in the background, each converted
+// to a row ordered to match the column list with missing keys sent as NULL
+func startJSONLFeeder(ctx context.Context, file *os.File) ([]string, <-chan []string) {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	var columns []string
+	var buffered []string
+	if COLUMNS_FLAG != "" {
+		columns = strings.Split(COLUMNS_FLAG, ",")
+	} else {
+		columns, buffered = inferJSONLColumns(scanner, INFER_LINES)
+	}
+
+	rows := make(chan []string, 1024)
+	go func() {
+		defer close(rows)
+
+		emit := func(line string) bool {
+			row, err := jsonLineToRow(line, columns)
+			if err != nil {
+				log.Printf("skipping malformed jsonl line: %s\n", err.Error())
+				return true
+			}
+			select {
+			case rows <- row:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, line := range buffered {
+			if ctx.Err() != nil {
+				return
+			}
+			if !emit(line) {
+				return
+			}
+		}
+		for scanner.Scan() {
+			if ctx.Err() != nil {
+				return
+			}
+			if !emit(scanner.Text()) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
 			log.Fatal(err.Error())
 		}
+	}()
+	return columns, rows
+}
+
+// inferJSONLColumns scans up to limit lines for the union of their top-level
+// JSON keys, in first-seen order, returning the scanned raw lines alongside so
+// the caller can replay them as data once scanning stops
+func inferJSONLColumns(scanner *bufio.Scanner, limit int) (columns []string, buffered []string) {
+	seen := make(map[string]bool)
+	for i := 0; i < limit && scanner.Scan(); i++ {
+		line := scanner.Text()
+		buffered = append(buffered, line)
+
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			continue
+		}
+		for k := range obj {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	return columns, buffered
+}
+
+// jsonLineToRow decodes a jsonl line into a row ordered to match columns,
+// emitting the NULL sentinel for keys that are absent or explicitly null.
+// Note: this reuses the same "NULL" sentinel repalceNULLByDEFAULT already
+// treats as SQL NULL for tsv/csv input, so a field whose actual string value
+// is the literal "NULL" is indistinguishable from a missing/null key and is
+// written as SQL NULL instead.
+func jsonLineToRow(line string, columns []string) ([]string, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		return nil, err
+	}
 
-		if isFirstRow {
-			parseColumns(record, &query)
-			isFirstRow = false
-		} else if <-available {		// wait for available database connection
-			id++
-			wg.Add(1)
-			args := repalceNULLByDEFAULT(record)
-			go insert(id, query, db, callback, &connections, &wg, args)
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		v, ok := obj[c]
+		if !ok || v == nil {
+			row[i] = "NULL"
+			continue
+		}
+		if s, ok := v.(string); ok {
+			row[i] = s
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
 		}
+		row[i] = string(b)
+	}
+	return row, nil
+}
+
+// runSQLMode turns the tool into a general-purpose parallel query runner: it
+// splits stdin into statements on lines beginning with "#" (the pt-query-digest
+// block delimiter) and dispatches each one to the worker pool via db.Exec,
+// ignoring -table entirely.
+func runSQLMode(ctx context.Context, file *os.File, db *sql.DB) {
+	start := time.Now()
+
+	statements := startSQLFeeder(ctx, file)
+
+	callback 	:= make(chan int, 1024)
+	done		:= make(chan struct{})
+	insertions := int64(0)
+	fails := int64(0)
+
+	startLogger()
+	startConnectionController(&insertions, &fails, callback, done)
+
+	var wg sync.WaitGroup
+	for i := 0; i < WORKERS; i++ {
+		wg.Add(1)
+		go sqlWorker(ctx, i, db, statements, callback, &wg)
 	}
 	wg.Wait()
 
-	for ;len(callback) > 0; {}
+	close(callback)
+	<-done
+
 	elapsed := time.Since(start)
 	log.Printf("Execution time: %s\n", elapsed)
-	log.Printf("Status: %d insertions, %d valid insertions\n", insertions, insertions - fails)
-	log.Printf("QPS: %d , Valid QPS: %d\n",
-			  insertions *1000000000/elapsed.Nanoseconds(), (insertions - fails) *1000000000/elapsed.Nanoseconds())
+	log.Printf("Status: %d statements executed, %d failed\n", insertions, fails)
+	log.Printf("QPS: %d\n", insertions*1000000000/elapsed.Nanoseconds())
 }
 
-// inserts data into database
-func insert(id int, query string, db *sql.DB, callback chan<- int, connections *int64, wg *sync.WaitGroup, args []interface{}) {
-	// make a new statement for every insert,
-	// this is quite inefficient, but since all inserts are running concurrently,
-	// it's still faster than using a single prepared statement and
-	// inserting the data sequentielly.
-	// we have to close the statement after the routine terminates,
-	// so that the connection to the database is released and can be reused
-	atomic.AddInt64(connections, 1)
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		log.Fatal(err.Error())
+// startSQLFeeder splits file into statements on lines beginning with "#" and
+// streams them onto a channel in the background
+func startSQLFeeder(ctx context.Context, file *os.File) <-chan string {
+	statements := make(chan string, 1024)
+	go func() {
+		defer close(statements)
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+		var buf strings.Builder
+		flush := func() bool {
+			stmt := strings.TrimSpace(buf.String())
+			buf.Reset()
+			if stmt == "" {
+				return true
+			}
+			select {
+			case statements <- stmt:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for scanner.Scan() {
+			if ctx.Err() != nil {
+				return
+			}
+			line := scanner.Text()
+			if strings.HasPrefix(line, "#") {
+				if !flush() {
+					return
+				}
+				continue
+			}
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err.Error())
+		}
+		flush()
+	}()
+	return statements
+}
+
+// sqlWorker consumes statements off the shared channel and executes each one
+// directly via db.Exec, bounded by QUERY_TIMEOUT
+func sqlWorker(ctx context.Context, id int, db *sql.DB, statements <-chan string, callback chan<- int, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for stmt := range statements {
+		queryCtx, queryCancel := withQueryTimeout(ctx)
+		inflightConnections.Inc()
+		execStart := time.Now()
+		_, err := db.ExecContext(queryCtx, stmt)
+		recordBatchResult(1, err == nil, time.Since(execStart))
+		inflightConnections.Dec()
+		queryCancel()
+
+		if err != nil {
+			log.Printf("Worker %d: statement failed: %s\n%s\n", id, err.Error(), stmt)
+			callback <- -1
+		} else {
+			callback <- 1
+		}
 	}
+}
+
+// worker consumes rows off the shared channel, accumulates them into batches of
+// BATCH_SIZE and issues one multi-row INSERT per batch using a prepared statement
+// reused for the worker's entire lifetime. A partial final batch is flushed once
+// the channel is drained, using a one-off statement sized to match. Each batch
+// runs inside its own transaction and is retried on transient errors before being
+// reported as failed.
+func worker(ctx context.Context, id int, columns []string, db *sql.DB, rows <-chan []string, callback chan<- int, wg *sync.WaitGroup, rejects *rejectWriter, started *int64) {
 	defer wg.Done()
+
+	query := buildInsertQuery(columns, BATCH_SIZE)
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		// a single worker failing to prepare must not abort the whole load: log
+		// and return, the remaining workers keep draining the shared row channel.
+		// main() checks started once every worker has exited, to catch the case
+		// where every worker failed the same way (bad -table, missing grant)
+		log.Printf("Worker %d: failed to prepare insert statement, exiting: %s\n", id, err.Error())
+		return
+	}
+	atomic.AddInt64(started, 1)
 	defer stmt.Close()
 
-	_, err = stmt.Exec(args...)
+	flush := func(batch [][]string) {
+		if len(batch) == 0 {
+			return
+		}
+
+		var execQuery string
+		if len(batch) == BATCH_SIZE {
+			execQuery = query
+		} else {
+			execQuery = buildInsertQuery(columns, len(batch))
+		}
+
+		batchStart := time.Now()
+		execErr := execBatchWithRetry(ctx, id, db, stmt, execQuery, len(batch) == BATCH_SIZE, flattenArgs(batch))
+		recordBatchResult(len(batch), execErr == nil, time.Since(batchStart))
+
+		if execErr != nil {
+			log.Printf("Worker %d: batch of %d rows failed after %d retries: %s\n", id, len(batch), MAX_RETRIES, execErr.Error())
+			if rejects != nil {
+				if err := rejects.Write(batch); err != nil {
+					log.Printf("Worker %d: failed to write rejected rows to %s: %s\n", id, REJECT_FILE, err.Error())
+				}
+			}
+			callback <- -len(batch)
+		} else {
+			callback <- len(batch)
+		}
+	}
+
+	batch := make([][]string, 0, BATCH_SIZE)
+	for record := range rows {
+		batch = append(batch, record)
+		if len(batch) == BATCH_SIZE {
+			flush(batch)
+			batch = batch[:0]
+		}
+	}
+	flush(batch)	// flush partial final batch at EOF
+}
+
+// execBatchWithRetry runs one batch inside its own transaction, retrying with
+// jittered exponential backoff while the error is transient (deadlock, lock wait
+// timeout, TiDB write conflict, or a dropped connection), up to MAX_RETRIES times.
+// Retries stop early once ctx is cancelled.
+func execBatchWithRetry(ctx context.Context, id int, db *sql.DB, stmt *sql.Stmt, query string, reuseStmt bool, args []interface{}) error {
+	var err error
+	for attempt := 0; attempt <= MAX_RETRIES; attempt++ {
+		inflightConnections.Inc()
+		err = execBatchTx(ctx, db, stmt, query, reuseStmt, args)
+		inflightConnections.Dec()
+
+		if err == nil {
+			return nil
+		}
+		if !isRetryableErr(err) || attempt == MAX_RETRIES || ctx.Err() != nil {
+			return err
+		}
+
+		reason := classifyRetryReason(err)
+		recordRetry(reason)
+		backoff := jitteredBackoff(attempt)
+		log.Printf("Worker %d: transient error (%s) on attempt %d/%d, retrying in %s: %s\n", id, reason, attempt+1, MAX_RETRIES, backoff, err.Error())
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+	return err
+}
+
+// execBatchTx wraps a single batch INSERT in a transaction bounded by QUERY_TIMEOUT,
+// reusing the worker's prepared statement for full-size batches and preparing a
+// one-off statement inside the transaction for the partial final batch
+func execBatchTx(ctx context.Context, db *sql.DB, stmt *sql.Stmt, query string, reuseStmt bool, args []interface{}) error {
+	queryCtx, queryCancel := withQueryTimeout(ctx)
+	defer queryCancel()
+
+	tx, err := db.BeginTx(queryCtx, nil)
 	if err != nil {
-		log.Printf("Row Number: %d, %s\n", id, err.Error())
-		id = -id
+		return err
+	}
+
+	var execErr error
+	if reuseStmt {
+		_, execErr = tx.StmtContext(queryCtx, stmt).ExecContext(queryCtx, args...)
+	} else {
+		_, execErr = tx.ExecContext(queryCtx, query, args...)
+	}
+
+	if execErr != nil {
+		tx.Rollback()
+		return execErr
+	}
+	return tx.Commit()
+}
+
+// withQueryTimeout derives a child context bounded by QUERY_TIMEOUT, or returns
+// ctx unchanged when QUERY_TIMEOUT is disabled (<= 0)
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, QUERY_TIMEOUT)
+}
+
+// withTimeout derives a child context bounded by timeout, or returns ctx
+// unchanged when timeout is disabled (<= 0)
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isRetryableErr reports whether err is a transient MySQL/TiDB error (deadlock,
+// lock wait timeout, write conflict) or a driver-level dropped connection
+func isRetryableErr(err error) bool {
+	if err == driver.ErrBadConn {
+		return true
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		return retryableErrNumbers[mysqlErr.Number]
+	}
+	return strings.Contains(err.Error(), "invalid connection")
+}
+
+// classifyRetryReason labels a transient error for the retries_total{reason}
+// counter, matching the conditions isRetryableErr treats as retryable
+func classifyRetryReason(err error) string {
+	if err == driver.ErrBadConn {
+		return "bad_conn"
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		switch mysqlErr.Number {
+		case 1213:
+			return "deadlock"
+		case 1205:
+			return "lock_wait_timeout"
+		case 8022, 9007:
+			return "write_conflict"
+		}
+	}
+	if strings.Contains(err.Error(), "invalid connection") {
+		return "conn_reset"
 	}
-	atomic.AddInt64(connections, -1)
-	// finished inserting, send id over channel to signalize termination of routine
-	callback <- id
+	return "other"
 }
 
-// controls termination of program and number of connections to database
-func startConnectionController(insertions, fails, connections *int64, callback <-chan int, available chan<- bool) {
-	go func() { for {
-		atomic.AddInt64(insertions, 1)	// a routine terminated, increment counter
-		id := <-callback	// returns id of terminated routine
-		if (id<0) {
-		  atomic.AddInt64(fails, 1)
+// jitteredBackoff returns an exponentially growing delay for the given retry
+// attempt (0-indexed) with up to 50% random jitter added, to avoid every worker
+// retrying a contended batch at the exact same instant
+func jitteredBackoff(attempt int) time.Duration {
+	base := 100 * time.Millisecond
+	delay := base * time.Duration(int64(1)<<uint(attempt))
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay + jitter
+}
+
+// tallies insertions and fails as batches report back over callback, row by row,
+// then signals done once the channel has been closed and fully drained
+func startConnectionController(insertions, fails *int64, callback <-chan int, done chan<- struct{}) {
+	go func() {
+		for n := range callback {
+			if n < 0 {
+				atomic.AddInt64(insertions, int64(-n))
+				atomic.AddInt64(fails, int64(-n))
+			} else {
+				atomic.AddInt64(insertions, int64(n))
+			}
 		}
-		available <- true	// make new connection available
-	}}()
+		done <- struct{}{}
+	}()
 }
 
-// print status update to console every second
-func startLogger(insertions, fails, connections *int64) {
+// startLogger prints a human-readable status line once a second, reading from
+// the same Prometheus registry /metrics scrapes
+func startLogger() {
 	go func() {
 		c := time.Tick(time.Second)
 		for {
 			<-c
-			log.Printf("Status: %d insertions, %d inuse connections, %d fails\n", *insertions, *connections, *fails)
+			log.Printf("Status: %.0f insertions, %.0f inuse connections, %.0f fails\n",
+				counterValue(rowsInsertedTotal), gaugeValue(inflightConnections), counterValue(rowsFailedTotal))
 		}
 	}()
 }
 
-// parse csv columns, create query statement
-func parseColumns(columns []string, query *string) {
-	*query = "INSERT INTO "+TABLENAME+" ("
-	placeholder := "VALUES ("
-	update := "ON DUPLICATE KEY UPDATE "
+// parse the header row into the list of column names used to build queries
+func parseColumnNames(header []string) []string {
+	return header
+}
+
+// build an "INSERT INTO t (cols) VALUES (?,...),(?,...),..." statement sized for
+// batchSize rows, appending the ON DUPLICATE KEY UPDATE suffix when enabled
+func buildInsertQuery(columns []string, batchSize int) string {
+	query := "INSERT INTO " + TABLENAME + " (" + strings.Join(columns, ", ") + ") VALUES "
+
+	tuple := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	tuples := make([]string, batchSize)
+	for i := range tuples {
+		tuples[i] = tuple
+	}
+	query += strings.Join(tuples, ", ")
+
+	if ON_DUP_KEYS_UPDATE {
+		query += " ON DUPLICATE KEY UPDATE " + buildUpdateClause(columns)
+	}
+	return query
+}
+
+// build the "col=VALUES(col), ..." clause shared by every ON DUPLICATE KEY UPDATE
+func buildUpdateClause(columns []string) string {
+	clauses := make([]string, len(columns))
 	for i, c := range columns {
-		if i == 0 {
-			*query += c
-			placeholder += "?"
-			update += (c + "=VALUES(" + c + ")")
-		} else {
-			*query += ", "+c
-			placeholder += ", ?"
-			update += (", "+ c + "=VALUES(" + c + ")")
+		clauses[i] = c + "=VALUES(" + c + ")"
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// loadFromReader streams file through a registered reader handler and issues a
+// single LOAD DATA LOCAL INFILE, which is dramatically faster than row-by-row or
+// batched INSERTs for bulk ingestion. The header row is parsed for its column
+// list but still passed through to MySQL, which skips it via IGNORE 1 LINES.
+// Only tsv input is supported: nullTokenFilter splits on DELIMITER with no
+// CSV-quote awareness, so it cannot safely rewrite a quoted csv field.
+func loadFromReader(ctx context.Context, file *os.File, db *sql.DB) {
+	start := time.Now()
+
+	buffered := bufio.NewReader(file)
+	header, err := buffered.ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatal(err.Error())
+	}
+	columns := parseColumnNames(strings.Split(strings.TrimRight(header, "\r\n"), string(DELIMITER)))
+
+	mysql.RegisterReaderHandler(loadReaderHandler, func() io.Reader {
+		return nullTokenFilter(io.MultiReader(strings.NewReader(header), buffered))
+	})
+	defer mysql.DeregisterReaderHandler(loadReaderHandler)
+
+	// a bulk load can legitimately run far longer than a single batch insert, so
+	// it gets its own timeout knob instead of sharing QUERY_TIMEOUT's 30s default
+	queryCtx, queryCancel := withTimeout(ctx, LOAD_TIMEOUT)
+	defer queryCancel()
+
+	result, err := db.ExecContext(queryCtx, buildLoadQuery(columns))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	rows, _ := result.RowsAffected()
+
+	elapsed := time.Since(start)
+	log.Printf("Execution time: %s\n", elapsed)
+	log.Printf("Status: %d rows loaded\n", rows)
+}
+
+// build a LOAD DATA LOCAL INFILE statement reading from the registered reader
+// handler, skipping the header line that MySQL will see at the front of the stream
+func buildLoadQuery(columns []string) string {
+	return fmt.Sprintf(
+		"LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s FIELDS TERMINATED BY '%s' IGNORE 1 LINES (%s)",
+		loadReaderHandler, TABLENAME, string(DELIMITER), strings.Join(columns, ", "),
+	)
+}
+
+// nullTokenFilter wraps r, rewriting every exact "NULL" field to MySQL's \N
+// NULL-escape sequence before the bytes reach the LOAD DATA parser
+func nullTokenFilter(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+		var werr error
+		for werr == nil && scanner.Scan() {
+			fields := strings.Split(scanner.Text(), string(DELIMITER))
+			for i, f := range fields {
+				if f == "NULL" {
+					fields[i] = `\N`
+				}
+			}
+			_, werr = pw.Write([]byte(strings.Join(fields, string(DELIMITER)) + "\n"))
 		}
+		if werr == nil {
+			werr = scanner.Err()
+		}
+		pw.CloseWithError(werr)
+	}()
+	return pr
+}
+
+// appendDSNParam adds a "key=value" parameter to a DSN, joining it with the
+// existing query string if there is one
+func appendDSNParam(dsn, param string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + param
 	}
-	placeholder += ")"
-	*query += ") " + placeholder
-	if ON_DUP_KEYS_UPDATE {
-		*query += update
+	return dsn + "?" + param
+}
+
+// flatten a batch of rows into the flat []interface{} argument list expected by
+// the corresponding multi-row INSERT, applying the usual NULL-token substitution
+func flattenArgs(batch [][]string) []interface{} {
+	if len(batch) == 0 {
+		return nil
 	}
+	args := make([]interface{}, 0, len(batch)*len(batch[0]))
+	for _, row := range batch {
+		args = append(args, repalceNULLByDEFAULT(row)...)
+	}
+	return args
+}
+
+// rejectWriter appends the rows of permanently failed batches to a TSV file so
+// an operator can re-run just the failing rows, guarding concurrent writes from
+// the worker pool with a mutex
+type rejectWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newRejectWriter(path string) (*rejectWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &rejectWriter{file: file}, nil
+}
+
+func (r *rejectWriter) Write(batch [][]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, row := range batch {
+		if _, err := r.file.WriteString(strings.Join(row, "\t") + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rejectWriter) Close() error {
+	return r.file.Close()
 }
 
 // convert []string to []interface{}